@@ -25,6 +25,7 @@
 package cniovs
 
 import (
+	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
@@ -32,6 +33,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types/current"
@@ -47,6 +49,11 @@ import (
 //
 const defaultBridge = "br0"
 
+// ovsdbTimeout bounds every OVSDB transaction AddOnHost/DelFromHost kick
+// off, so a wedged ovsdb-server fails the CNI call instead of hanging it
+// past the runtime's own ADD/DEL timeout.
+const ovsdbTimeout = 30 * time.Second
+
 //
 // Types
 //
@@ -62,32 +69,49 @@ func (cniOvs CniOvs) AddOnHost(conf *usrsptypes.NetConf, args *skel.CmdArgs, ipR
 
 	logging.Debugf("OVS AddOnHost: ENTER")
 
+	ctx, cancel := context.WithTimeout(context.Background(), ovsdbTimeout)
+	defer cancel()
+
 	//
 	// Manditory attribute of "ovs-vsctl add-port" is a BridgeName. If NetType is not
 	// set to "bridge", should request fail or added to default bridge. Existing
 	// behavior hardcoded BridgeName to "br0". So if not entered, default to "br0".
 	// Can be change later to return ERROR if needed.
 	//
-	if conf.HostConf.NetType != "bridge" {
-		conf.HostConf.NetType = "bridge"
-		conf.HostConf.BridgeConf.BridgeName = defaultBridge
-	}
+	// Representor ports resolve their own bridge from the VF's PF (see
+	// addLocalDeviceRepresentor) and OVN ports always land on the OVN
+	// integration bridge (see addLocalDeviceOvn), so both skip this
+	// default-bridge coercion and creation entirely.
+	usesOvn := conf.HostConf.OvnConf.LogicalSwitchPort != ""
+	if conf.HostConf.IfType != "representor" && !usesOvn {
+		if conf.HostConf.NetType != "bridge" {
+			conf.HostConf.NetType = "bridge"
+			conf.HostConf.BridgeConf.BridgeName = defaultBridge
+		}
 
-	//
-	// If Network Type is Bridge, Create it first before creating Interface
-	//
-	if conf.HostConf.NetType == "bridge" {
-		err = addLocalNetworkBridge(conf, args, &data)
-		if err != nil {
-			return err
+		//
+		// If Network Type is Bridge, Create it first before creating Interface
+		//
+		if conf.HostConf.NetType == "bridge" {
+			err = addLocalNetworkBridge(ctx, conf, args, &data)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
 	//
 	// Create Local Interface
 	//
-	if conf.HostConf.IfType == "vhostuser" {
-		err = addLocalDeviceVhost(conf, args, &data)
+	if err = validateVhostConf(conf.HostConf.VhostConf); err != nil {
+		return err
+	}
+	if usesOvn {
+		err = addLocalDeviceOvn(ctx, conf, args, &data)
+	} else if conf.HostConf.IfType == "vhostuser" {
+		err = addLocalDeviceVhost(ctx, conf, args, &data)
+	} else if conf.HostConf.IfType == "representor" {
+		err = addLocalDeviceRepresentor(ctx, conf, args, &data)
 	} else {
 		err = errors.New("ERROR: Unknown HostConf.IfType:" + conf.HostConf.IfType)
 	}
@@ -129,6 +153,9 @@ func (cniOvs CniOvs) DelFromHost(conf *usrsptypes.NetConf, args *skel.CmdArgs) e
 
 	logging.Debugf("OVS DelFromHost: ENTER")
 
+	ctx, cancel := context.WithTimeout(context.Background(), ovsdbTimeout)
+	defer cancel()
+
 	//
 	// Load Config - Retrieved squirreled away data needed for processing delete
 	//
@@ -143,9 +170,12 @@ func (cniOvs CniOvs) DelFromHost(conf *usrsptypes.NetConf, args *skel.CmdArgs) e
 	// behavior hardcoded BrdigeName to "br0". So if not entered, default to "br0".
 	// Can be change later to return ERROR if needed.
 	//
-	if conf.HostConf.NetType != "bridge" {
-		conf.HostConf.NetType = "bridge"
-		conf.HostConf.BridgeConf.BridgeName = defaultBridge
+	usesOvn := conf.HostConf.OvnConf.LogicalSwitchPort != ""
+	if conf.HostConf.IfType != "representor" && !usesOvn {
+		if conf.HostConf.NetType != "bridge" {
+			conf.HostConf.NetType = "bridge"
+			conf.HostConf.BridgeConf.BridgeName = defaultBridge
+		}
 	}
 
 	//
@@ -155,8 +185,12 @@ func (cniOvs CniOvs) DelFromHost(conf *usrsptypes.NetConf, args *skel.CmdArgs) e
 	//
 	// Delete Local Interface
 	//
-	if conf.HostConf.IfType == "vhostuser" {
-		err = delLocalDeviceVhost(conf, args, &data)
+	if usesOvn {
+		err = delLocalDeviceOvn(ctx, conf, args, &data)
+	} else if conf.HostConf.IfType == "vhostuser" {
+		err = delLocalDeviceVhost(ctx, conf, args, &data)
+	} else if conf.HostConf.IfType == "representor" {
+		err = delLocalDeviceRepresentor(ctx, conf, args, &data)
 	} else {
 		err = errors.New("ERROR: Unknown HostConf.Type:" + conf.HostConf.IfType)
 	}
@@ -167,8 +201,8 @@ func (cniOvs CniOvs) DelFromHost(conf *usrsptypes.NetConf, args *skel.CmdArgs) e
 	//
 	// Delete Bridge if empty
 	//
-	if conf.HostConf.NetType == "bridge" {
-		err = delLocalNetworkBridge(conf, args, &data)
+	if conf.HostConf.NetType == "bridge" && conf.HostConf.IfType != "representor" && !usesOvn {
+		err = delLocalNetworkBridge(ctx, conf, args, &data)
 		if err != nil {
 			return err
 		}
@@ -201,7 +235,7 @@ func generateRandomMacAddress() string {
 	return macAddr
 }
 
-func addLocalDeviceVhost(conf *usrsptypes.NetConf, args *skel.CmdArgs, data *ovsdb.OvsSavedData) error {
+func addLocalDeviceVhost(ctx context.Context, conf *usrsptypes.NetConf, args *skel.CmdArgs, data *ovsdb.OvsSavedData) error {
 	var err error
 	var vhostName string
 	var bridgeName string
@@ -231,19 +265,30 @@ func addLocalDeviceVhost(conf *usrsptypes.NetConf, args *skel.CmdArgs, data *ovs
 	}
 
 	// ovs-vsctl add-port
-	if vhostName, err = createVhostPort(sockDir, sockRef, clientMode, bridgeName); err == nil {
-		if vhostPortMac, err := getVhostPortMac(vhostName); err == nil {
+	vhostName, err = createVhostPort(ctx, sockDir, sockRef, clientMode, bridgeName, conf.HostConf.VhostConf)
+	// CNI Add can be retried with the same config - a port another
+	// concurrent Add already created is success, not failure.
+	if errors.Is(err, ovsdb.ErrPortExists) {
+		vhostName = sockRef
+		err = nil
+	}
+	if err == nil {
+		if vhostPortMac, macErr := getVhostPortMac(vhostName); macErr == nil {
 			data.VhostMac = vhostPortMac
 		}
 
 		data.Vhostname = vhostName
 		data.IfMac = generateRandomMacAddress()
+		data.BridgeName = bridgeName
+		data.VlanTag = conf.HostConf.VhostConf.VlanTag
+		data.Trunks = conf.HostConf.VhostConf.Trunks
+		data.MTU = conf.HostConf.VhostConf.MTU
 	}
 
 	return err
 }
 
-func delLocalDeviceVhost(conf *usrsptypes.NetConf, args *skel.CmdArgs, data *ovsdb.OvsSavedData) error {
+func delLocalDeviceVhost(ctx context.Context, conf *usrsptypes.NetConf, args *skel.CmdArgs, data *ovsdb.OvsSavedData) error {
 	var bridgeName string
 
 	// Validate and convert input data
@@ -252,7 +297,20 @@ func delLocalDeviceVhost(conf *usrsptypes.NetConf, args *skel.CmdArgs, data *ovs
 	}
 
 	// ovs-vsctl --if-exists del-port
-	if err := deleteVhostPort(data.Vhostname, bridgeName); err == nil {
+	expected := &ovsdb.PortState{VlanTag: data.VlanTag, Trunks: data.Trunks, MTU: data.MTU}
+	err := deleteVhostPort(ctx, data.Vhostname, bridgeName, expected)
+	// Del is idempotent - a port that's already gone is success.
+	if errors.Is(err, ovsdb.ErrNotFound) {
+		err = nil
+	}
+	if errors.Is(err, ovsdb.ErrNotManaged) {
+		// Port predates this plugin tagging its own (e.g. an install
+		// upgraded mid-series) - it was never ours to delete, so don't
+		// block Del over it.
+		logging.Debugf("OVS delLocalDeviceVhost: port %s is not managed by userspace-cni, leaving it in place", data.Vhostname)
+		err = nil
+	}
+	if err == nil {
 		//path := filepath.Join(usrspdb.DefaultSocketDir, args.ContainerID)
 		path := usrspdb.DefaultSocketDir
 
@@ -290,23 +348,37 @@ func delLocalDeviceVhost(conf *usrsptypes.NetConf, args *skel.CmdArgs, data *ovs
 	return nil
 }
 
-func addLocalNetworkBridge(conf *usrsptypes.NetConf, args *skel.CmdArgs, data *ovsdb.OvsSavedData) error {
+func addLocalNetworkBridge(ctx context.Context, conf *usrsptypes.NetConf, args *skel.CmdArgs, data *ovsdb.OvsSavedData) error {
 	var err error
 
-	if found := findBridge(conf.HostConf.BridgeConf.BridgeName); found == false {
-		if err = createBridge(conf.HostConf.BridgeConf.BridgeName); err == nil {
-			// Nothing to do at this time
+	if found := findBridge(ctx, conf.HostConf.BridgeConf.BridgeName); found == false {
+		err = createBridge(ctx, conf.HostConf.BridgeConf.BridgeName)
+		// CNI Add can be retried with the same config - a bridge another
+		// concurrent Add already created is success, not failure.
+		if errors.Is(err, ovsdb.ErrBridgeExists) {
+			err = nil
 		}
 	}
 
 	return err
 }
 
-func delLocalNetworkBridge(conf *usrsptypes.NetConf, args *skel.CmdArgs, data *ovsdb.OvsSavedData) error {
+func delLocalNetworkBridge(ctx context.Context, conf *usrsptypes.NetConf, args *skel.CmdArgs, data *ovsdb.OvsSavedData) error {
 	var err error
 
-	if containInterfaces := doesBridgeContainInterfaces(conf.HostConf.BridgeConf.BridgeName); containInterfaces == false {
-		err = deleteBridge(conf.HostConf.BridgeConf.BridgeName)
+	if containInterfaces := doesBridgeContainInterfaces(ctx, conf.HostConf.BridgeConf.BridgeName); containInterfaces == false {
+		err = deleteBridge(ctx, conf.HostConf.BridgeConf.BridgeName)
+		if errors.Is(err, ovsdb.ErrNotFound) {
+			// Already gone - Del is idempotent.
+			err = nil
+		}
+		if errors.Is(err, ovsdb.ErrNotManaged) {
+			// Pre-existing bridge (e.g. a host's default br0, or one
+			// created before this plugin tagged its own) was never
+			// ours to delete - leave it alone instead of failing Del.
+			logging.Debugf("OVS delLocalNetworkBridge: bridge %s is not managed by userspace-cni, leaving it in place", conf.HostConf.BridgeConf.BridgeName)
+			err = nil
+		}
 	}
 
 	return err
@@ -0,0 +1,135 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module implements the OVN integration path: instead of a
+// user-chosen bridge, the vhost-user port is bound on OVN's integration
+// bridge (br-int) with external_ids:iface-id set to the Logical_Switch_Port
+// name, and - if an NB connection is configured - a matching
+// Logical_Switch_Port row is created in OVN's northbound database.
+//
+
+package cniovs
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	"github.com/intel/userspace-cni-network-plugin/cniovs/ovsdb"
+	"github.com/intel/userspace-cni-network-plugin/usrspdb"
+	"github.com/intel/userspace-cni-network-plugin/usrsptypes"
+)
+
+// resolveOvnPort returns the Logical_Switch_Port name to use: the "ovnPort"
+// key in CNI_ARGS if the runtime (e.g. Multus) set one, otherwise
+// OvnConf.LogicalSwitchPort from the network config.
+func resolveOvnPort(conf *usrsptypes.NetConf, args *skel.CmdArgs) string {
+	for _, pair := range strings.Split(args.Args, ";") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 && kv[0] == "ovnPort" && kv[1] != "" {
+			return kv[1]
+		}
+	}
+
+	return conf.HostConf.OvnConf.LogicalSwitchPort
+}
+
+// addLocalDeviceOvn binds a vhost-user port for conf.HostConf.OvnConf on the
+// OVN integration bridge, optionally registering a matching
+// Logical_Switch_Port in OVN NB.
+func addLocalDeviceOvn(ctx context.Context, conf *usrsptypes.NetConf, args *skel.CmdArgs, data *ovsdb.OvsSavedData) error {
+	ovnConf := conf.HostConf.OvnConf
+	lspName := resolveOvnPort(conf, args)
+
+	client, err := getOvsClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	brInt, err := client.IntegrationBridge(ctx)
+	if err != nil {
+		return err
+	}
+
+	sockRef := strings.Join([]string{args.ContainerID[:12], args.IfName}, "-")
+	sockDir := usrspdb.DefaultSocketDir
+
+	port := &ovsdb.Port{Name: sockRef}
+	iface := &ovsdb.Interface{
+		Name:        sockRef,
+		Type:        "dpdkvhostuserclient",
+		Options:     map[string]string{"vhost-server-path": sockDir + "/" + sockRef},
+		ExternalIDs: map[string]string{"iface-id": lspName},
+	}
+
+	if err := client.CreatePort(ctx, brInt, port, iface); err != nil {
+		// CNI Add can be retried with the same config - a port another
+		// concurrent Add already created is success, not failure.
+		if !errors.Is(err, ovsdb.ErrPortExists) {
+			return err
+		}
+	}
+
+	data.Vhostname = sockRef
+	data.IfMac = generateRandomMacAddress()
+	data.BridgeName = brInt
+	data.OvnLogicalSwitch = ovnConf.LogicalSwitch
+	data.OvnLogicalSwitchPort = lspName
+
+	if ovnConf.NBConnection == "" {
+		return nil
+	}
+
+	ovnClient, err := ovsdb.NewOvnClient(ctx, ovnConf.NBConnection)
+	if err != nil {
+		return err
+	}
+	defer ovnClient.Disconnect()
+
+	if err := ovnClient.CreateLogicalSwitchPort(ctx, ovnConf.LogicalSwitch, lspName, ovnConf.Addresses); err != nil {
+		return err
+	}
+	data.OvnNbCreated = true
+
+	return nil
+}
+
+// delLocalDeviceOvn removes the OVS port and, if Add created one, the OVN NB
+// Logical_Switch_Port that addLocalDeviceOvn set up.
+func delLocalDeviceOvn(ctx context.Context, conf *usrsptypes.NetConf, args *skel.CmdArgs, data *ovsdb.OvsSavedData) error {
+	client, err := getOvsClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Del is idempotent - a port that's already gone is success.
+	if err := client.DeletePort(ctx, data.BridgeName, data.Vhostname, nil); err != nil && !errors.Is(err, ovsdb.ErrNotFound) {
+		return err
+	}
+
+	if !data.OvnNbCreated {
+		return nil
+	}
+
+	ovnClient, err := ovsdb.NewOvnClient(ctx, conf.HostConf.OvnConf.NBConnection)
+	if err != nil {
+		return err
+	}
+	defer ovnClient.Disconnect()
+
+	return ovnClient.DeleteLogicalSwitchPort(ctx, data.OvnLogicalSwitch, data.OvnLogicalSwitchPort)
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module talks to the local ovsdb-server to create and remove the
+// Bridge/Port/Interface rows backing a vhost-user CNI interface. It used to
+// shell out to ovs-vsctl; it now drives a single cniovs/ovsdb.Client
+// connection so every Add/Del is a transactional OVSDB call instead of a
+// forked process and some string parsing of its output. Every helper takes
+// the context.Context AddOnHost/DelFromHost derive from the CNI call's
+// timeout budget, so a wedged ovsdb-server can't hang kubelet.
+//
+
+package cniovs
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/intel/userspace-cni-network-plugin/cniovs/ovsdb"
+	"github.com/intel/userspace-cni-network-plugin/usrsptypes"
+)
+
+//
+// Globals
+//
+
+var (
+	ovsClientOnce sync.Once
+	ovsClient     ovsdb.Client
+	ovsClientErr  error
+)
+
+// getOvsClient lazily dials ovsdb-server and caches the connection for the
+// lifetime of the process. ctx only bounds the initial dial - it has no
+// effect on later calls once the client is cached.
+func getOvsClient(ctx context.Context) (ovsdb.Client, error) {
+	ovsClientOnce.Do(func() {
+		ovsClient, ovsClientErr = ovsdb.NewClient(ctx)
+	})
+	return ovsClient, ovsClientErr
+}
+
+//
+// Bridge Functions
+//
+
+func findBridge(ctx context.Context, bridgeName string) bool {
+	client, err := getOvsClient(ctx)
+	if err != nil {
+		return false
+	}
+
+	_, found, err := client.FindBridge(ctx, bridgeName)
+	if err != nil {
+		return false
+	}
+	return found
+}
+
+func createBridge(ctx context.Context, bridgeName string) error {
+	client, err := getOvsClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	return client.CreateBridge(ctx, bridgeName)
+}
+
+func deleteBridge(ctx context.Context, bridgeName string) error {
+	client, err := getOvsClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	return client.DeleteBridge(ctx, bridgeName)
+}
+
+func doesBridgeContainInterfaces(ctx context.Context, bridgeName string) bool {
+	client, err := getOvsClient(ctx)
+	if err != nil {
+		return true
+	}
+
+	bridge, found, err := client.FindBridge(ctx, bridgeName)
+	if err != nil || !found {
+		return true
+	}
+	return len(bridge.Ports) != 0
+}
+
+// validateVhostConf rejects OVS port option combinations OVS itself would
+// reject, so AddOnHost fails fast with a clear message instead of a cryptic
+// OVSDB constraint violation.
+func validateVhostConf(vhostConf usrsptypes.VhostConf) error {
+	if vhostConf.VlanTag != 0 && len(vhostConf.Trunks) != 0 {
+		return fmt.Errorf("ERROR: VhostConf.VlanTag and VhostConf.Trunks are mutually exclusive (access port vs trunk port)")
+	}
+	return nil
+}
+
+//
+// Port Functions
+//
+
+// createVhostPort adds a dpdkvhostuser(client) port named sockRef, backed
+// by the socket sockDir/sockRef, to bridgeName, applying the VLAN, MTU,
+// port-type and other_config/external_ids knobs from vhostConf.
+func createVhostPort(ctx context.Context, sockDir string, sockRef string, clientMode bool, bridgeName string, vhostConf usrsptypes.VhostConf) (string, error) {
+	client, err := getOvsClient(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	ifaceType := "dpdkvhostuser"
+	if clientMode {
+		ifaceType = "dpdkvhostuserclient"
+	}
+	if vhostConf.IfType != "" {
+		ifaceType = vhostConf.IfType
+	}
+
+	options := map[string]string{}
+	if ifaceType == "dpdkvhostuserclient" {
+		options["vhost-server-path"] = filepath.Join(sockDir, sockRef)
+	}
+
+	port := &ovsdb.Port{
+		Name:        sockRef,
+		Trunks:      vhostConf.Trunks,
+		OtherConfig: vhostConf.OtherConfig,
+		ExternalIDs: vhostConf.ExternalIDs,
+	}
+	if vhostConf.VlanTag != 0 {
+		port.Tag = &vhostConf.VlanTag
+	}
+
+	iface := &ovsdb.Interface{
+		Name:    sockRef,
+		Type:    ifaceType,
+		Options: options,
+	}
+	if vhostConf.MTU != 0 {
+		iface.MTURequest = &vhostConf.MTU
+	}
+
+	if err := client.CreatePort(ctx, bridgeName, port, iface); err != nil {
+		return "", err
+	}
+
+	return sockRef, nil
+}
+
+func deleteVhostPort(ctx context.Context, vhostName string, bridgeName string, expected *ovsdb.PortState) error {
+	client, err := getOvsClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	return client.DeletePort(ctx, bridgeName, vhostName, expected)
+}
+
+// getVhostPortMac always returns an error: no MAC address is read from
+// OVSDB for a vhost-user interface, so the caller leaves the saved VhostMac
+// unset.
+func getVhostPortMac(vhostName string) (string, error) {
+	return "", fmt.Errorf("ERROR: no mac address recorded for interface %s", vhostName)
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cniovs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/intel/userspace-cni-network-plugin/cniovs/ovsdb"
+	"github.com/intel/userspace-cni-network-plugin/usrsptypes"
+)
+
+func TestValidateVhostConf(t *testing.T) {
+	tests := []struct {
+		name      string
+		vhostConf usrsptypes.VhostConf
+		wantErr   bool
+	}{
+		{name: "neither tag nor trunks", vhostConf: usrsptypes.VhostConf{}, wantErr: false},
+		{name: "tag only", vhostConf: usrsptypes.VhostConf{VlanTag: 100}, wantErr: false},
+		{name: "trunks only", vhostConf: usrsptypes.VhostConf{Trunks: []int{10, 20}}, wantErr: false},
+		{name: "tag and trunks are mutually exclusive", vhostConf: usrsptypes.VhostConf{VlanTag: 100, Trunks: []int{10, 20}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateVhostConf(tt.vhostConf)
+			if tt.wantErr && err == nil {
+				t.Errorf("expected an error, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+// fakeOvsClient is a minimal ovsdb.Client double - the mock seam
+// ovsdb.Client was built for - used to exercise addLocalNetworkBridge and
+// delLocalNetworkBridge's idempotent-retry handling without dialing a real
+// ovsdb-server.
+type fakeOvsClient struct {
+	bridge      *ovsdb.Bridge
+	bridgeFound bool
+
+	createBridgeErr error
+	deleteBridgeErr error
+}
+
+func (f *fakeOvsClient) FindBridge(ctx context.Context, name string) (*ovsdb.Bridge, bool, error) {
+	return f.bridge, f.bridgeFound, nil
+}
+
+func (f *fakeOvsClient) FindBridgeByPort(ctx context.Context, portName string) (string, bool, error) {
+	return "", false, nil
+}
+
+func (f *fakeOvsClient) CreateBridge(ctx context.Context, name string) error {
+	return f.createBridgeErr
+}
+
+func (f *fakeOvsClient) DeleteBridge(ctx context.Context, name string) error {
+	return f.deleteBridgeErr
+}
+
+func (f *fakeOvsClient) CreatePort(ctx context.Context, bridge string, port *ovsdb.Port, iface *ovsdb.Interface) error {
+	return nil
+}
+
+func (f *fakeOvsClient) DeletePort(ctx context.Context, bridge, portName string, expected *ovsdb.PortState) error {
+	return nil
+}
+
+func (f *fakeOvsClient) IntegrationBridge(ctx context.Context) (string, error) {
+	return "br-int", nil
+}
+
+func (f *fakeOvsClient) Disconnect() {}
+
+// useFakeClient installs client as the process-wide ovsdb client for the
+// duration of the test, standing in for the dial getOvsClient would
+// otherwise do to /var/run/openvswitch/db.sock.
+func useFakeClient(t *testing.T, client ovsdb.Client) {
+	t.Helper()
+
+	ovsClientOnce = sync.Once{}
+	ovsClientOnce.Do(func() {})
+	ovsClient = client
+	ovsClientErr = nil
+
+	t.Cleanup(func() {
+		ovsClientOnce = sync.Once{}
+		ovsClient = nil
+		ovsClientErr = nil
+	})
+}
+
+func TestAddLocalNetworkBridgeTreatsBridgeExistsAsSuccess(t *testing.T) {
+	useFakeClient(t, &fakeOvsClient{bridgeFound: false, createBridgeErr: ovsdb.ErrBridgeExists})
+
+	conf := &usrsptypes.NetConf{}
+	conf.HostConf.BridgeConf.BridgeName = "br0"
+
+	if err := addLocalNetworkBridge(context.Background(), conf, nil, nil); err != nil {
+		t.Fatalf("expected ErrBridgeExists to be treated as success, got %v", err)
+	}
+}
+
+func TestAddLocalNetworkBridgePropagatesOtherErrors(t *testing.T) {
+	wantErr := errors.New("boom")
+	useFakeClient(t, &fakeOvsClient{bridgeFound: false, createBridgeErr: wantErr})
+
+	conf := &usrsptypes.NetConf{}
+	conf.HostConf.BridgeConf.BridgeName = "br0"
+
+	if err := addLocalNetworkBridge(context.Background(), conf, nil, nil); !errors.Is(err, wantErr) {
+		t.Fatalf("expected %v, got %v", wantErr, err)
+	}
+}
+
+func TestDelLocalNetworkBridgeTreatsNotFoundAsSuccess(t *testing.T) {
+	useFakeClient(t, &fakeOvsClient{
+		bridge:          &ovsdb.Bridge{Name: "br0"},
+		bridgeFound:     true,
+		deleteBridgeErr: ovsdb.ErrNotFound,
+	})
+
+	conf := &usrsptypes.NetConf{}
+	conf.HostConf.BridgeConf.BridgeName = "br0"
+
+	if err := delLocalNetworkBridge(context.Background(), conf, nil, nil); err != nil {
+		t.Fatalf("expected ErrNotFound to be treated as success, got %v", err)
+	}
+}
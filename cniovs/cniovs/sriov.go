@@ -0,0 +1,234 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module implements the HW-offload path: given the PCI BDF of an
+// SR-IOV VF (HostConf.DeviceID), it walks sysfs to find the VF's PF, the OVS
+// bridge that PF (or its bond) is already plugged into, and the VF's
+// representor netdev, then wires the representor into that bridge. This
+// mirrors ovs-cni's offload flow so a pod requesting a VF gets the matching
+// dpdk-on-VF datapath instead of a plain kernel netdevice.
+//
+
+package cniovs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	"github.com/intel/userspace-cni-network-plugin/cniovs/ovsdb"
+	"github.com/intel/userspace-cni-network-plugin/logging"
+	"github.com/intel/userspace-cni-network-plugin/usrsptypes"
+)
+
+const sysBusPci = "/sys/bus/pci/devices"
+
+// resolvePfNetdev returns the netdev name of deviceID's physical function,
+// e.g. "0000:04:00.1" -> "ens785f1".
+func resolvePfNetdev(deviceID string) (string, error) {
+	netDir := filepath.Join(sysBusPci, deviceID, "physfn", "net")
+	entries, err := os.ReadDir(netDir)
+	if err != nil {
+		return "", fmt.Errorf("ERROR: unable to resolve PF for VF %s: %v", deviceID, err)
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("ERROR: PF of VF %s has no netdev", deviceID)
+	}
+
+	return entries[0].Name(), nil
+}
+
+// resolveVfIndex returns the numerical VF index of deviceID relative to its
+// PF, e.g. "0000:04:00.1" plugged in as virtfn3 -> 3.
+func resolveVfIndex(deviceID string) (int, error) {
+	pfDir := filepath.Join(sysBusPci, deviceID, "physfn")
+	entries, err := os.ReadDir(pfDir)
+	if err != nil {
+		return -1, fmt.Errorf("ERROR: unable to read PF dir for VF %s: %v", deviceID, err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "virtfn") {
+			continue
+		}
+
+		link, err := os.Readlink(filepath.Join(pfDir, name))
+		if err != nil {
+			continue
+		}
+		if filepath.Base(link) == deviceID {
+			return strconv.Atoi(strings.TrimPrefix(name, "virtfn"))
+		}
+	}
+
+	return -1, fmt.Errorf("ERROR: unable to find virtfn index of VF %s", deviceID)
+}
+
+// findRepresentor returns the representor netdev for VF vfIndex of PF
+// pfName, e.g. pfName="ens785f1", vfIndex=3 -> "ens785f1_3".
+func findRepresentor(pfName string, vfIndex int) (string, error) {
+	vfDir := filepath.Join("/sys/class/net", pfName, "device/sriov", strconv.Itoa(vfIndex), "net")
+	entries, err := os.ReadDir(vfDir)
+	if err != nil {
+		return "", fmt.Errorf("ERROR: unable to find representor for %s vf %d: %v", pfName, vfIndex, err)
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("ERROR: no representor netdev under %s", vfDir)
+	}
+
+	return entries[0].Name(), nil
+}
+
+// bondMaster returns the name of the bonding netdev pfName is enslaved to,
+// if any, e.g. pfName="ens785f1" bonded into "bond0" -> "bond0", true.
+func bondMaster(pfName string) (string, bool) {
+	link, err := os.Readlink(filepath.Join("/sys/class/net", pfName, "master"))
+	if err != nil {
+		return "", false
+	}
+	return filepath.Base(link), true
+}
+
+// findBridgeForPf returns the name of the OVS bridge that already has
+// pfName (or the bond it's enslaved to) as one of its ports, if any.
+func findBridgeForPf(ctx context.Context, pfName string) (string, bool) {
+	client, err := getOvsClient(ctx)
+	if err != nil {
+		return "", false
+	}
+
+	if bridgeName, found, err := client.FindBridgeByPort(ctx, pfName); err == nil && found {
+		return bridgeName, true
+	}
+
+	// PF itself isn't a bridge port directly - it may be bonded, in
+	// which case the bridge owns the bond's netdev instead.
+	if bondName, bonded := bondMaster(pfName); bonded {
+		if bridgeName, found, err := client.FindBridgeByPort(ctx, bondName); err == nil && found {
+			return bridgeName, true
+		}
+	}
+
+	return "", false
+}
+
+// resolveOffloadBridge picks the bridge a representor port should be added
+// to: the bridge already carrying deviceID's PF, falling back to the
+// user-configured bridge if that can't be determined.
+func resolveOffloadBridge(ctx context.Context, conf *usrsptypes.NetConf) (string, error) {
+	deviceID := conf.HostConf.DeviceID
+
+	pfName, err := resolvePfNetdev(deviceID)
+	if err == nil {
+		if bridgeName, found := findBridgeForPf(ctx, pfName); found {
+			return bridgeName, nil
+		}
+		logging.Warningf("SRIOV: no bridge owns PF %s of VF %s, falling back to configured bridge", pfName, deviceID)
+	} else {
+		logging.Warningf("SRIOV: %v, falling back to configured bridge", err)
+	}
+
+	if conf.HostConf.BridgeConf.BridgeName == "" {
+		return "", fmt.Errorf("ERROR: unable to resolve bridge for VF %s and no bridge configured", deviceID)
+	}
+	return conf.HostConf.BridgeConf.BridgeName, nil
+}
+
+// addLocalDeviceRepresentor plugs the representor netdev for
+// conf.HostConf.DeviceID into the resolved offload bridge.
+func addLocalDeviceRepresentor(ctx context.Context, conf *usrsptypes.NetConf, args *skel.CmdArgs, data *ovsdb.OvsSavedData) error {
+	deviceID := conf.HostConf.DeviceID
+
+	pfName, err := resolvePfNetdev(deviceID)
+	if err != nil {
+		return err
+	}
+	vfIndex, err := resolveVfIndex(deviceID)
+	if err != nil {
+		return err
+	}
+	repName, err := findRepresentor(pfName, vfIndex)
+	if err != nil {
+		return err
+	}
+
+	bridgeName, err := resolveOffloadBridge(ctx, conf)
+	if err != nil {
+		return err
+	}
+
+	client, err := getOvsClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	port := &ovsdb.Port{Name: repName}
+	iface := &ovsdb.Interface{
+		Name: repName,
+		ExternalIDs: map[string]string{
+			"contIface": args.IfName,
+			"contNetns": args.Netns,
+			"deviceID":  deviceID,
+		},
+	}
+
+	if err := client.CreatePort(ctx, bridgeName, port, iface); err != nil {
+		// CNI Add can be retried with the same config - a representor
+		// port another concurrent Add already created is success, not
+		// failure.
+		if !errors.Is(err, ovsdb.ErrPortExists) {
+			return err
+		}
+	}
+
+	data.Vhostname = repName
+	data.DeviceID = deviceID
+	data.BridgeName = bridgeName
+	return nil
+}
+
+// delLocalDeviceRepresentor removes the representor port added by
+// addLocalDeviceRepresentor, resolving the bridge the same way Add did since
+// the bridge name isn't required to have been persisted.
+func delLocalDeviceRepresentor(ctx context.Context, conf *usrsptypes.NetConf, args *skel.CmdArgs, data *ovsdb.OvsSavedData) error {
+	bridgeName := data.BridgeName
+	if bridgeName == "" {
+		var err error
+		bridgeName, err = resolveOffloadBridge(ctx, conf)
+		if err != nil {
+			return err
+		}
+	}
+
+	client, err := getOvsClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Del is idempotent - a representor port that's already gone is
+	// success.
+	err = client.DeletePort(ctx, bridgeName, data.Vhostname, nil)
+	if errors.Is(err, ovsdb.ErrNotFound) {
+		return nil
+	}
+	return err
+}
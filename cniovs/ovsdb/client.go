@@ -0,0 +1,554 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module wraps github.com/ovn-org/libovsdb so the rest of the cniovs
+// package can create and remove Bridge/Port/Interface rows with a single
+// transaction instead of shelling out to ovs-vsctl. The Client interface is
+// the seam tests use to inject a mock instead of dialing the real
+// ovsdb-server unix socket. Every call takes the caller's context.Context so
+// a wedged ovsdb-server can't hang the CNI ADD/DEL past the runtime's own
+// timeout.
+//
+
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	libovsdb "github.com/ovn-org/libovsdb/client"
+	"github.com/ovn-org/libovsdb/model"
+	"github.com/ovn-org/libovsdb/ovsdb"
+
+	"github.com/intel/userspace-cni-network-plugin/logging"
+)
+
+//
+// Constants
+//
+const (
+	defaultOvsdbSocket = "unix:/var/run/openvswitch/db.sock"
+	ovsDatabase        = "Open_vSwitch"
+
+	// managedExternalID is stamped on every Bridge/Port this plugin
+	// creates, so delete paths can tell ours apart from ones an admin
+	// (or another plugin) set up by hand.
+	managedExternalID = "userspace-cni:managed"
+
+	transactRetries    = 3
+	transactRetryDelay = 200 * time.Millisecond
+)
+
+//
+// Types
+//
+
+// Bridge models a row of the OVS Bridge table.
+type Bridge struct {
+	UUID        string            `ovsdb:"_uuid"`
+	Name        string            `ovsdb:"name"`
+	Ports       []string          `ovsdb:"ports"`
+	ExternalIDs map[string]string `ovsdb:"external_ids"`
+}
+
+// Port models a row of the OVS Port table.
+type Port struct {
+	UUID        string            `ovsdb:"_uuid"`
+	Name        string            `ovsdb:"name"`
+	Interfaces  []string          `ovsdb:"interfaces"`
+	Tag         *int              `ovsdb:"tag"`
+	Trunks      []int             `ovsdb:"trunks"`
+	OtherConfig map[string]string `ovsdb:"other_config"`
+	ExternalIDs map[string]string `ovsdb:"external_ids"`
+}
+
+// Interface models a row of the OVS Interface table.
+type Interface struct {
+	UUID        string            `ovsdb:"_uuid"`
+	Name        string            `ovsdb:"name"`
+	Type        string            `ovsdb:"type"`
+	Options     map[string]string `ovsdb:"options"`
+	OfPort      *int              `ovsdb:"ofport"`
+	Error       *string           `ovsdb:"error"`
+	MTURequest  *int              `ovsdb:"mtu_request"`
+	ExternalIDs map[string]string `ovsdb:"external_ids"`
+}
+
+// OpenVSwitch models the single row of the Open_vSwitch table.
+type OpenVSwitch struct {
+	UUID        string            `ovsdb:"_uuid"`
+	Bridges     []string          `ovsdb:"bridges"`
+	ExternalIDs map[string]string `ovsdb:"external_ids"`
+}
+
+// PortState is the subset of Port/Interface fields a caller applied when it
+// created a port, so DeletePort can confirm the live port is still the one
+// it created before removing it instead of trusting the managed external_id
+// tag alone.
+type PortState struct {
+	VlanTag int
+	Trunks  []int
+	MTU     int
+}
+
+// Client is the interface the cniovs package uses to talk to the local
+// ovsdb-server. It is deliberately small - just what AddOnHost/DelFromHost
+// need - so tests can provide a mock implementation instead of dialing the
+// real /var/run/openvswitch/db.sock socket. Every method takes a
+// context.Context so the CNI ADD/DEL timeout budget is honored instead of a
+// wedged ovsdb-server hanging kubelet.
+type Client interface {
+	// FindBridge returns the Bridge row with the given name, if any.
+	FindBridge(ctx context.Context, name string) (*Bridge, bool, error)
+	// FindBridgeByPort returns the name of the Bridge row that owns a
+	// port with the given name, if any.
+	FindBridgeByPort(ctx context.Context, portName string) (string, bool, error)
+	// CreateBridge creates an empty bridge and attaches it to the
+	// Open_vSwitch table in a single transaction.
+	CreateBridge(ctx context.Context, name string) error
+	// DeleteBridge removes a bridge from the Open_vSwitch table.
+	DeleteBridge(ctx context.Context, name string) error
+	// CreatePort inserts a Port row (and its backing Interface row) and
+	// attaches it to bridge, all in a single transaction. On return,
+	// iface.OfPort is populated from the server; port.UUID and iface.UUID
+	// hold the transaction's named UUIDs, not the server-assigned ones.
+	CreatePort(ctx context.Context, bridge string, port *Port, iface *Interface) error
+	// DeletePort removes a named port from a bridge. If expected is
+	// non-nil, the live port's VLAN/trunk/MTU configuration is compared
+	// against it first, and ErrPortMismatch is returned instead of
+	// deleting if the port no longer matches.
+	DeletePort(ctx context.Context, bridge, portName string, expected *PortState) error
+	// IntegrationBridge returns the name of OVN's integration bridge, as
+	// recorded in Open_vSwitch.external_ids:ovn-bridge, defaulting to
+	// "br-int" if that key isn't set.
+	IntegrationBridge(ctx context.Context) (string, error)
+	// Disconnect tears down the connection to ovsdb-server.
+	Disconnect()
+}
+
+type ovsdbClient struct {
+	mu      sync.Mutex
+	handle  libovsdb.Client
+	ovsUUID string
+}
+
+//
+// API Functions
+//
+
+// NewClient dials ovsdb-server over its local unix socket, caches the
+// singleton Open_vSwitch row UUID and returns a ready to use Client.
+func NewClient(ctx context.Context) (Client, error) {
+	dbModel, err := model.NewClientDBModel(ovsDatabase, map[string]model.Model{
+		"Open_vSwitch": &OpenVSwitch{},
+		"Bridge":       &Bridge{},
+		"Port":         &Port{},
+		"Interface":    &Interface{},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: failed to build ovsdb client model: %v", err)
+	}
+
+	handle, err := libovsdb.NewOVSDBClient(dbModel, libovsdb.WithEndpoint(defaultOvsdbSocket))
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: failed to create ovsdb client: %v", err)
+	}
+
+	if err := handle.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("ERROR: failed to connect to %s: %v", defaultOvsdbSocket, err)
+	}
+
+	if _, err := handle.Monitor(ctx, handle.NewMonitor()); err != nil {
+		handle.Disconnect()
+		return nil, fmt.Errorf("ERROR: failed to monitor ovsdb: %v", err)
+	}
+
+	c := &ovsdbClient{handle: handle}
+	if err := c.cacheOvsUUID(ctx); err != nil {
+		handle.Disconnect()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *ovsdbClient) Disconnect() {
+	c.handle.Disconnect()
+}
+
+func (c *ovsdbClient) cacheOvsUUID(ctx context.Context) error {
+	var rows []OpenVSwitch
+	if err := c.handle.List(ctx, &rows); err != nil {
+		return fmt.Errorf("ERROR: failed to list Open_vSwitch table: %v", err)
+	}
+	if len(rows) != 1 {
+		return fmt.Errorf("ERROR: expected exactly one Open_vSwitch row, found %d", len(rows))
+	}
+
+	c.mu.Lock()
+	c.ovsUUID = rows[0].UUID
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *ovsdbClient) FindBridge(ctx context.Context, name string) (*Bridge, bool, error) {
+	var rows []Bridge
+	if err := c.handle.WhereCache(func(b *Bridge) bool {
+		return b.Name == name
+	}).List(ctx, &rows); err != nil {
+		return nil, false, fmt.Errorf("ERROR: failed to look up bridge %s: %v", name, err)
+	}
+	if len(rows) == 0 {
+		return nil, false, nil
+	}
+	return &rows[0], true, nil
+}
+
+func (c *ovsdbClient) IntegrationBridge(ctx context.Context) (string, error) {
+	var rows []OpenVSwitch
+	if err := c.handle.List(ctx, &rows); err != nil {
+		return "", fmt.Errorf("ERROR: failed to list Open_vSwitch table: %v", err)
+	}
+	if len(rows) != 1 {
+		return "", fmt.Errorf("ERROR: expected exactly one Open_vSwitch row, found %d", len(rows))
+	}
+
+	if bridge, ok := rows[0].ExternalIDs["ovn-bridge"]; ok && bridge != "" {
+		return bridge, nil
+	}
+	return "br-int", nil
+}
+
+func (c *ovsdbClient) FindBridgeByPort(ctx context.Context, portName string) (string, bool, error) {
+	var ports []Port
+	if err := c.handle.WhereCache(func(p *Port) bool {
+		return p.Name == portName
+	}).List(ctx, &ports); err != nil {
+		return "", false, fmt.Errorf("ERROR: failed to look up port %s: %v", portName, err)
+	}
+	if len(ports) == 0 {
+		return "", false, nil
+	}
+
+	var bridges []Bridge
+	if err := c.handle.List(ctx, &bridges); err != nil {
+		return "", false, fmt.Errorf("ERROR: failed to list bridges: %v", err)
+	}
+	for _, bridge := range bridges {
+		for _, p := range bridge.Ports {
+			if p == ports[0].UUID {
+				return bridge.Name, true, nil
+			}
+		}
+	}
+
+	return "", false, nil
+}
+
+func (c *ovsdbClient) CreateBridge(ctx context.Context, name string) error {
+	if _, found, err := c.FindBridge(ctx, name); err != nil {
+		return err
+	} else if found {
+		return ErrBridgeExists
+	}
+
+	bridge := &Bridge{UUID: "newbridge", Name: name, ExternalIDs: map[string]string{managedExternalID: "true"}}
+	insertBridgeOp, err := c.handle.Create(bridge)
+	if err != nil {
+		return fmt.Errorf("ERROR: failed to build bridge insert op: %v", err)
+	}
+
+	c.mu.Lock()
+	ovsUUID := c.ovsUUID
+	c.mu.Unlock()
+
+	ovs := &OpenVSwitch{UUID: ovsUUID}
+	mutateOp, err := c.handle.Where(ovs).Mutate(ovs,
+		model.Mutation{Field: &ovs.Bridges, Mutator: ovsdb.MutateOperationInsert, Value: []string{bridge.UUID}})
+	if err != nil {
+		return fmt.Errorf("ERROR: failed to build Open_vSwitch mutate op: %v", err)
+	}
+
+	ops := append(insertBridgeOp, mutateOp...)
+	return c.transact(ctx, fmt.Sprintf("create bridge %s", name), ops)
+}
+
+func (c *ovsdbClient) DeleteBridge(ctx context.Context, name string) error {
+	bridge, found, err := c.FindBridge(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
+	}
+	if bridge.ExternalIDs[managedExternalID] != "true" {
+		return ErrNotManaged
+	}
+
+	c.mu.Lock()
+	ovsUUID := c.ovsUUID
+	c.mu.Unlock()
+
+	ovs := &OpenVSwitch{UUID: ovsUUID}
+	mutateOp, err := c.handle.Where(ovs).Mutate(ovs,
+		model.Mutation{Field: &ovs.Bridges, Mutator: ovsdb.MutateOperationDelete, Value: []string{bridge.UUID}})
+	if err != nil {
+		return fmt.Errorf("ERROR: failed to build Open_vSwitch mutate op: %v", err)
+	}
+	deleteOp, err := c.handle.Where(bridge).Delete()
+	if err != nil {
+		return fmt.Errorf("ERROR: failed to build bridge delete op: %v", err)
+	}
+
+	ops := append(mutateOp, deleteOp...)
+	return c.transact(ctx, fmt.Sprintf("delete bridge %s", name), ops)
+}
+
+func (c *ovsdbClient) CreatePort(ctx context.Context, bridgeName string, port *Port, iface *Interface) error {
+	bridge, found, err := c.FindBridge(ctx, bridgeName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("ERROR: bridge %s does not exist", bridgeName)
+	}
+
+	var existing []Port
+	if err := c.handle.WhereCache(func(p *Port) bool {
+		return p.Name == port.Name
+	}).List(ctx, &existing); err != nil {
+		return fmt.Errorf("ERROR: failed to look up port %s: %v", port.Name, err)
+	}
+	if len(existing) != 0 {
+		return ErrPortExists
+	}
+
+	if port.ExternalIDs == nil {
+		port.ExternalIDs = map[string]string{}
+	}
+	port.ExternalIDs[managedExternalID] = "true"
+
+	iface.UUID = "newiface"
+	insertIfaceOp, err := c.handle.Create(iface)
+	if err != nil {
+		return fmt.Errorf("ERROR: failed to build interface insert op: %v", err)
+	}
+	port.UUID = "newport"
+	port.Interfaces = []string{iface.UUID}
+	insertPortOp, err := c.handle.Create(port)
+	if err != nil {
+		return fmt.Errorf("ERROR: failed to build port insert op: %v", err)
+	}
+	mutateOp, err := c.handle.Where(bridge).Mutate(bridge,
+		model.Mutation{Field: &bridge.Ports, Mutator: ovsdb.MutateOperationInsert, Value: []string{port.UUID}})
+	if err != nil {
+		return fmt.Errorf("ERROR: failed to build bridge mutate op: %v", err)
+	}
+
+	ops := append(append(insertIfaceOp, insertPortOp...), mutateOp...)
+	if err := c.transact(ctx, fmt.Sprintf("create port %s on bridge %s", port.Name, bridgeName), ops); err != nil {
+		return err
+	}
+
+	return c.waitForOfport(ctx, iface)
+}
+
+func (c *ovsdbClient) DeletePort(ctx context.Context, bridgeName, portName string, expected *PortState) error {
+	bridge, found, err := c.FindBridge(ctx, bridgeName)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
+	}
+
+	var ports []Port
+	if err := c.handle.WhereCache(func(p *Port) bool {
+		return p.Name == portName
+	}).List(ctx, &ports); err != nil {
+		return fmt.Errorf("ERROR: failed to look up port %s: %v", portName, err)
+	}
+	if len(ports) == 0 {
+		return ErrNotFound
+	}
+	if ports[0].ExternalIDs[managedExternalID] != "true" {
+		return ErrNotManaged
+	}
+	if expected != nil {
+		if err := c.assertPortState(ctx, &ports[0], expected); err != nil {
+			return err
+		}
+	}
+
+	mutateOp, err := c.handle.Where(bridge).Mutate(bridge,
+		model.Mutation{Field: &bridge.Ports, Mutator: ovsdb.MutateOperationDelete, Value: []string{ports[0].UUID}})
+	if err != nil {
+		return fmt.Errorf("ERROR: failed to build bridge mutate op: %v", err)
+	}
+	deleteOp, err := c.handle.Where(&ports[0]).Delete()
+	if err != nil {
+		return fmt.Errorf("ERROR: failed to build port delete op: %v", err)
+	}
+
+	ops := append(mutateOp, deleteOp...)
+	return c.transact(ctx, fmt.Sprintf("delete port %s from bridge %s", portName, bridgeName), ops)
+}
+
+// assertPortState confirms port's live VLAN/trunk/MTU configuration still
+// matches expected, so DeletePort doesn't tear down a port whose config was
+// changed out from under this plugin after it was created.
+func (c *ovsdbClient) assertPortState(ctx context.Context, port *Port, expected *PortState) error {
+	gotTag := 0
+	if port.Tag != nil {
+		gotTag = *port.Tag
+	}
+	if gotTag != expected.VlanTag || !intSetsEqual(port.Trunks, expected.Trunks) {
+		return fmt.Errorf("%w: port %s vlan/trunk config no longer matches", ErrPortMismatch, port.Name)
+	}
+
+	if expected.MTU == 0 || len(port.Interfaces) == 0 {
+		return nil
+	}
+
+	var ifaces []Interface
+	if err := c.handle.WhereCache(func(i *Interface) bool {
+		return i.UUID == port.Interfaces[0]
+	}).List(ctx, &ifaces); err != nil {
+		return fmt.Errorf("ERROR: failed to look up interface for port %s: %v", port.Name, err)
+	}
+	if len(ifaces) != 1 {
+		return fmt.Errorf("%w: port %s interface no longer exists", ErrPortMismatch, port.Name)
+	}
+
+	gotMTU := 0
+	if ifaces[0].MTURequest != nil {
+		gotMTU = *ifaces[0].MTURequest
+	}
+	if gotMTU != expected.MTU {
+		return fmt.Errorf("%w: port %s mtu no longer matches", ErrPortMismatch, port.Name)
+	}
+	return nil
+}
+
+// intSetsEqual reports whether a and b contain the same trunk IDs,
+// regardless of order - OVSDB's "trunks" column is a set, so ovsdb-server
+// is free to return it in a different order than it was submitted in.
+func intSetsEqual(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[int]int, len(a))
+	for _, v := range a {
+		counts[v]++
+	}
+	for _, v := range b {
+		counts[v]--
+	}
+	for _, n := range counts {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// waitForOfport polls the cached Interface row until ovs-vswitchd has
+// plugged the port and populated ofport or error, or ctx is done.
+func (c *ovsdbClient) waitForOfport(ctx context.Context, iface *Interface) error {
+	for {
+		var rows []Interface
+		if err := c.handle.WhereCache(func(i *Interface) bool {
+			return i.Name == iface.Name
+		}).List(ctx, &rows); err == nil && len(rows) == 1 {
+			if rows[0].Error != nil && *rows[0].Error != "" {
+				return fmt.Errorf("ERROR: interface %s failed to come up: %s", iface.Name, *rows[0].Error)
+			}
+			if rows[0].OfPort != nil && *rows[0].OfPort > 0 {
+				iface.OfPort = rows[0].OfPort
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("ERROR: timed out waiting for ofport on interface %s: %v", iface.Name, ctx.Err())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// isTransientError reports whether err looks like a dropped/reconnecting
+// ovsdb-server connection rather than a rejected transaction, so transact
+// knows it's worth a retry.
+func isTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "database connection failed") ||
+		strings.Contains(msg, "not connected") ||
+		strings.Contains(msg, "connection refused")
+}
+
+// transact runs ops in a single OVSDB transaction against ovsdb-server,
+// retrying transient connection failures with a short backoff.
+func (c *ovsdbClient) transact(ctx context.Context, desc string, ops []ovsdb.Operation) error {
+	return runTransact(ctx, c.handle, "OVSDB", desc, ops)
+}
+
+// runTransact runs ops as a single transaction over handle, retrying
+// transient connection failures with a short backoff, and emits one
+// structured log line per attempt with the transaction description, op
+// count, duration and outcome - the OVSDB equivalent of logging an
+// ovs-vsctl argv/exit-code. Both the local ovsdb-server client and the OVN
+// NB client share this so a wedged connection to either gets the same
+// retry treatment.
+func runTransact(ctx context.Context, handle libovsdb.Client, logPrefix, desc string, ops []ovsdb.Operation) error {
+	var err error
+	for attempt := 1; attempt <= transactRetries; attempt++ {
+		start := time.Now()
+		var results []ovsdb.OperationResult
+		results, err = handle.Transact(ctx, ops...)
+		duration := time.Since(start)
+
+		if err == nil {
+			if _, checkErr := ovsdb.CheckOperationResults(results, ops); checkErr != nil {
+				logging.Debugf("%s transact: %s (%d ops, %v) - rejected: %v", logPrefix, desc, len(ops), duration, checkErr)
+				return fmt.Errorf("ERROR: %s transaction (%s) rejected: %v", logPrefix, desc, checkErr)
+			}
+
+			logging.Debugf("%s transact: %s (%d ops, %v) - OK", logPrefix, desc, len(ops), duration)
+			return nil
+		}
+
+		logging.Debugf("%s transact: %s (%d ops, %v) - attempt %d/%d failed: %v",
+			logPrefix, desc, len(ops), duration, attempt, transactRetries, err)
+
+		if !isTransientError(err) || attempt == transactRetries {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("ERROR: %s transaction (%s) canceled: %v", logPrefix, desc, ctx.Err())
+		case <-time.After(transactRetryDelay):
+		}
+	}
+
+	return fmt.Errorf("ERROR: %s transaction (%s) failed: %v", logPrefix, desc, err)
+}
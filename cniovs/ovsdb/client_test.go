@@ -0,0 +1,89 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovsdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestIntSetsEqual(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []int
+		b    []int
+		want bool
+	}{
+		{name: "both nil", a: nil, b: nil, want: true},
+		{name: "same order", a: []int{10, 20, 30}, b: []int{10, 20, 30}, want: true},
+		{name: "reordered", a: []int{30, 10, 20}, b: []int{10, 20, 30}, want: true},
+		{name: "different length", a: []int{10, 20}, b: []int{10, 20, 30}, want: false},
+		{name: "different elements", a: []int{10, 20, 30}, b: []int{10, 20, 40}, want: false},
+		{name: "duplicate counts differ", a: []int{10, 10, 20}, b: []int{10, 20, 20}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := intSetsEqual(tt.a, tt.b); got != tt.want {
+				t.Errorf("intSetsEqual(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAssertPortState covers the VLAN/trunk comparison, including the
+// reordered-trunks case that regressed when intSetsEqual was still
+// intSlicesEqual (fixed in 431db19). It deliberately only exercises paths
+// that return before the MTU lookup (expected.MTU == 0, or a port with no
+// interfaces), since the MTU branch reads from c.handle, which isn't set up
+// here.
+func TestAssertPortState(t *testing.T) {
+	c := &ovsdbClient{}
+
+	tag := 100
+	port := &Port{Name: "vhost0", Tag: &tag, Trunks: []int{30, 10, 20}}
+
+	t.Run("matching tag and reordered trunks", func(t *testing.T) {
+		expected := &PortState{VlanTag: 100, Trunks: []int{10, 20, 30}}
+		if err := c.assertPortState(context.Background(), port, expected); err != nil {
+			t.Errorf("expected no mismatch, got %v", err)
+		}
+	})
+
+	t.Run("tag mismatch", func(t *testing.T) {
+		expected := &PortState{VlanTag: 200, Trunks: []int{10, 20, 30}}
+		err := c.assertPortState(context.Background(), port, expected)
+		if !errors.Is(err, ErrPortMismatch) {
+			t.Errorf("expected ErrPortMismatch, got %v", err)
+		}
+	})
+
+	t.Run("trunks mismatch", func(t *testing.T) {
+		expected := &PortState{VlanTag: 100, Trunks: []int{10, 20, 40}}
+		err := c.assertPortState(context.Background(), port, expected)
+		if !errors.Is(err, ErrPortMismatch) {
+			t.Errorf("expected ErrPortMismatch, got %v", err)
+		}
+	})
+
+	t.Run("mtu expected but no interfaces recorded - skips DB lookup", func(t *testing.T) {
+		bare := &Port{Name: "vhost1", Trunks: nil}
+		expected := &PortState{MTU: 1500}
+		if err := c.assertPortState(context.Background(), bare, expected); err != nil {
+			t.Errorf("expected no mismatch when port has no interfaces to check, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ovsdb
+
+import "errors"
+
+//
+// Sentinel errors returned by the Bridge/Port lifecycle calls below. Callers
+// use errors.Is() to tell an already-exists or already-gone condition apart
+// from a real OVSDB failure, so Add/Del can treat CNI's "call again with the
+// same config" retries as success instead of failing the pod.
+//
+var (
+	// ErrBridgeExists is returned by CreateBridge when the bridge is
+	// already present.
+	ErrBridgeExists = errors.New("bridge already exists")
+
+	// ErrPortExists is returned by CreatePort when the port is already
+	// present on the bridge.
+	ErrPortExists = errors.New("port already exists")
+
+	// ErrNotFound is returned by DeleteBridge/DeletePort when the row to
+	// remove is already gone.
+	ErrNotFound = errors.New("not found")
+
+	// ErrNotManaged is returned by DeleteBridge/DeletePort when the
+	// bridge or port exists but wasn't tagged as created by this plugin,
+	// so tearing it down would remove one the admin set up by hand.
+	ErrNotManaged = errors.New("not managed by userspace-cni")
+
+	// ErrPortMismatch is returned by DeletePort when the live port's
+	// VLAN/trunk/MTU configuration no longer matches the PortState Add
+	// recorded, so deleting it could be removing a port someone else has
+	// since repurposed.
+	ErrPortMismatch = errors.New("port configuration changed since it was created")
+)
@@ -0,0 +1,172 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module is the OVN_Northbound counterpart of client.go: it manages
+// Logical_Switch_Port rows so a vhost-user port bound on the integration
+// bridge also gets a logical port in OVN, when the caller configures an NB
+// connection string.
+//
+
+package ovsdb
+
+import (
+	"context"
+	"fmt"
+
+	libovsdb "github.com/ovn-org/libovsdb/client"
+	"github.com/ovn-org/libovsdb/model"
+	"github.com/ovn-org/libovsdb/ovsdb"
+)
+
+const ovnNbDatabase = "OVN_Northbound"
+
+// LogicalSwitch models a row of OVN NB's Logical_Switch table.
+type LogicalSwitch struct {
+	UUID  string   `ovsdb:"_uuid"`
+	Name  string   `ovsdb:"name"`
+	Ports []string `ovsdb:"ports"`
+}
+
+// LogicalSwitchPort models a row of OVN NB's Logical_Switch_Port table.
+type LogicalSwitchPort struct {
+	UUID      string   `ovsdb:"_uuid"`
+	Name      string   `ovsdb:"name"`
+	Addresses []string `ovsdb:"addresses"`
+}
+
+// OvnClient manages Logical_Switch_Port rows in OVN's northbound database.
+// Every method takes a context.Context for the same reason as Client.
+type OvnClient interface {
+	// CreateLogicalSwitchPort creates lspName under logicalSwitch with
+	// the given addresses, attaching it to the switch in one
+	// transaction.
+	CreateLogicalSwitchPort(ctx context.Context, logicalSwitch, lspName string, addresses []string) error
+	// DeleteLogicalSwitchPort removes lspName from logicalSwitch.
+	DeleteLogicalSwitchPort(ctx context.Context, logicalSwitch, lspName string) error
+	// Disconnect tears down the connection to OVN NB.
+	Disconnect()
+}
+
+type ovnClient struct {
+	handle libovsdb.Client
+}
+
+// NewOvnClient dials the OVN NB database at connection (e.g.
+// "unix:/var/run/ovn/ovnnb_db.sock" or "tcp:10.0.0.1:6641").
+func NewOvnClient(ctx context.Context, connection string) (OvnClient, error) {
+	dbModel, err := model.NewClientDBModel(ovnNbDatabase, map[string]model.Model{
+		"Logical_Switch":      &LogicalSwitch{},
+		"Logical_Switch_Port": &LogicalSwitchPort{},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: failed to build OVN NB client model: %v", err)
+	}
+
+	handle, err := libovsdb.NewOVSDBClient(dbModel, libovsdb.WithEndpoint(connection))
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: failed to create OVN NB client: %v", err)
+	}
+
+	if err := handle.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("ERROR: failed to connect to %s: %v", connection, err)
+	}
+	if _, err := handle.Monitor(ctx, handle.NewMonitor()); err != nil {
+		handle.Disconnect()
+		return nil, fmt.Errorf("ERROR: failed to monitor OVN NB: %v", err)
+	}
+
+	return &ovnClient{handle: handle}, nil
+}
+
+func (c *ovnClient) Disconnect() {
+	c.handle.Disconnect()
+}
+
+func (c *ovnClient) findLogicalSwitch(ctx context.Context, name string) (*LogicalSwitch, bool, error) {
+	var rows []LogicalSwitch
+	if err := c.handle.WhereCache(func(ls *LogicalSwitch) bool {
+		return ls.Name == name
+	}).List(ctx, &rows); err != nil {
+		return nil, false, fmt.Errorf("ERROR: failed to look up logical switch %s: %v", name, err)
+	}
+	if len(rows) == 0 {
+		return nil, false, nil
+	}
+	return &rows[0], true, nil
+}
+
+func (c *ovnClient) CreateLogicalSwitchPort(ctx context.Context, logicalSwitch, lspName string, addresses []string) error {
+	ls, found, err := c.findLogicalSwitch(ctx, logicalSwitch)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("ERROR: logical switch %s does not exist", logicalSwitch)
+	}
+
+	lsp := &LogicalSwitchPort{UUID: "newlsp", Name: lspName, Addresses: addresses}
+	insertOp, err := c.handle.Create(lsp)
+	if err != nil {
+		return fmt.Errorf("ERROR: failed to build logical switch port insert op: %v", err)
+	}
+	mutateOp, err := c.handle.Where(ls).Mutate(ls,
+		model.Mutation{Field: &ls.Ports, Mutator: ovsdb.MutateOperationInsert, Value: []string{lsp.UUID}})
+	if err != nil {
+		return fmt.Errorf("ERROR: failed to build logical switch mutate op: %v", err)
+	}
+
+	ops := append(insertOp, mutateOp...)
+	return c.transact(ctx, fmt.Sprintf("create logical switch port %s on %s", lspName, logicalSwitch), ops)
+}
+
+func (c *ovnClient) DeleteLogicalSwitchPort(ctx context.Context, logicalSwitch, lspName string) error {
+	ls, found, err := c.findLogicalSwitch(ctx, logicalSwitch)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+
+	var lsps []LogicalSwitchPort
+	if err := c.handle.WhereCache(func(lsp *LogicalSwitchPort) bool {
+		return lsp.Name == lspName
+	}).List(ctx, &lsps); err != nil {
+		return fmt.Errorf("ERROR: failed to look up logical switch port %s: %v", lspName, err)
+	}
+	if len(lsps) == 0 {
+		return nil
+	}
+
+	mutateOp, err := c.handle.Where(ls).Mutate(ls,
+		model.Mutation{Field: &ls.Ports, Mutator: ovsdb.MutateOperationDelete, Value: []string{lsps[0].UUID}})
+	if err != nil {
+		return fmt.Errorf("ERROR: failed to build logical switch mutate op: %v", err)
+	}
+	deleteOp, err := c.handle.Where(&lsps[0]).Delete()
+	if err != nil {
+		return fmt.Errorf("ERROR: failed to build logical switch port delete op: %v", err)
+	}
+
+	ops := append(mutateOp, deleteOp...)
+	return c.transact(ctx, fmt.Sprintf("delete logical switch port %s from %s", lspName, logicalSwitch), ops)
+}
+
+// transact runs ops in a single transaction against OVN NB, sharing the
+// retry-on-transient-failure logic client.go's ovsdbClient.transact uses for
+// the local ovsdb-server connection.
+func (c *ovnClient) transact(ctx context.Context, desc string, ops []ovsdb.Operation) error {
+	return runTransact(ctx, c.handle, "OVN NB", desc, ops)
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// CNI only gives Del the original network config, not whatever AddOnHost
+// decided along the way (generated names, resolved bridges, ...). This
+// module squirrels that data away next to the container's vhost-user socket
+// so DelFromHost can undo exactly what Add did.
+//
+
+package ovsdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containernetworking/cni/pkg/skel"
+
+	"github.com/intel/userspace-cni-network-plugin/usrspdb"
+	"github.com/intel/userspace-cni-network-plugin/usrsptypes"
+)
+
+// OvsSavedData records what AddOnHost created, so DelFromHost can tear down
+// the same resources without having to recompute or re-resolve them.
+type OvsSavedData struct {
+	Vhostname  string `json:"vhostName,omitempty"`
+	VhostMac   string `json:"vhostMac,omitempty"`
+	IfMac      string `json:"ifMac,omitempty"`
+	DeviceID   string `json:"deviceID,omitempty"`
+	BridgeName string `json:"bridgeName,omitempty"`
+
+	// VlanTag/Trunks/MTU record the port options Add applied, so Del can
+	// pass them back to DeletePort as the expected ovsdb.PortState -
+	// confirming the live port's VLAN/trunk/MTU configuration still
+	// matches what Add set before tearing it down, rather than trusting
+	// the managed external_ids tag alone.
+	VlanTag int   `json:"vlanTag,omitempty"`
+	Trunks  []int `json:"trunks,omitempty"`
+	MTU     int   `json:"mtu,omitempty"`
+
+	// OvnLogicalSwitch/OvnLogicalSwitchPort record what Add resolved
+	// OvnConf.LogicalSwitch/LogicalSwitchPort to (the port name can be
+	// overridden per-pod via CNI_ARGS), and OvnNbCreated records whether
+	// Add also created a Logical_Switch_Port in OVN NB, so Del knows
+	// whether it has to remove one.
+	OvnLogicalSwitch     string `json:"ovnLogicalSwitch,omitempty"`
+	OvnLogicalSwitchPort string `json:"ovnLogicalSwitchPort,omitempty"`
+	OvnNbCreated         bool   `json:"ovnNbCreated,omitempty"`
+}
+
+func savedDataFile(args *skel.CmdArgs) string {
+	fileName := fmt.Sprintf("%s-%s.json", args.ContainerID[:12], args.IfName)
+	return filepath.Join(usrspdb.DefaultSocketDir, fileName)
+}
+
+// SaveConfig persists data so it can be retrieved by LoadConfig on Del.
+func SaveConfig(conf *usrsptypes.NetConf, args *skel.CmdArgs, data *OvsSavedData) error {
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("ERROR: failed to marshal saved OVS data: %v", err)
+	}
+
+	if err := os.MkdirAll(usrspdb.DefaultSocketDir, 0700); err != nil {
+		return fmt.Errorf("ERROR: failed to create %s: %v", usrspdb.DefaultSocketDir, err)
+	}
+
+	return os.WriteFile(savedDataFile(args), bytes, 0600)
+}
+
+// LoadConfig retrieves the data SaveConfig persisted during Add.
+func LoadConfig(conf *usrsptypes.NetConf, args *skel.CmdArgs, data *OvsSavedData) error {
+	bytes, err := os.ReadFile(savedDataFile(args))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("ERROR: failed to read saved OVS data: %v", err)
+	}
+
+	return json.Unmarshal(bytes, data)
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2018 Red Hat.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// This module defines the JSON schema accepted from the CNI runtime and
+// shared by the different plugin backends (cniovs, cniovpp, ...). It is
+// intentionally decoupled from any single backend - fields a given backend
+// doesn't understand are simply left unset.
+//
+
+package usrsptypes
+
+// NetConf is the top level configuration object unmarshalled from the CNI
+// network configuration file.
+type NetConf struct {
+	HostConf      HostConf      `json:"host,omitempty"`
+	ContainerConf ContainerConf `json:"container,omitempty"`
+}
+
+// HostConf describes how the plugin should provision the host side of the
+// interface.
+type HostConf struct {
+	Engine     string     `json:"engine,omitempty"`
+	IfType     string     `json:"iftype,omitempty"`
+	NetType    string     `json:"netType,omitempty"`
+	BridgeConf BridgeConf `json:"bridge,omitempty"`
+	VhostConf  VhostConf  `json:"vhost,omitempty"`
+
+	// DeviceID is the PCI BDF (e.g. "0000:04:00.1") of the SR-IOV VF this
+	// interface should be built on top of. With IfType "representor",
+	// AddOnHost resolves the VF's PF and representor from sysfs instead of
+	// requiring BridgeConf.BridgeName to be filled in by hand; other
+	// IfTypes ignore it.
+	DeviceID string `json:"deviceID,omitempty"`
+
+	// OvnConf, when its LogicalSwitchPort is set, routes the interface
+	// through OVN instead of a user-chosen bridge - see OvnConf.
+	OvnConf OvnConf `json:"ovn,omitempty"`
+}
+
+// OvnConf, when LogicalSwitchPort is set, attaches the interface to the OVN
+// integration bridge with its iface-id bound to that Logical_Switch_Port,
+// and, if NBConnection is also set, creates the Logical_Switch_Port in OVN's
+// northbound database. With LogicalSwitchPort empty, OvnConf is ignored.
+type OvnConf struct {
+	// LogicalSwitch is the OVN logical switch LogicalSwitchPort belongs
+	// to. Required to create the Logical_Switch_Port record.
+	LogicalSwitch string `json:"logicalSwitch,omitempty"`
+
+	// LogicalSwitchPort is the iface-id bound on the integration bridge
+	// port and, if NBConnection is set, the name of the
+	// Logical_Switch_Port created in OVN NB. May be overridden per-pod
+	// with the "ovnPort" CNI_ARGS key.
+	LogicalSwitchPort string `json:"logicalSwitchPort,omitempty"`
+
+	// Addresses are the MAC/IP pairs OVN should assign the port, e.g.
+	// ["0a:00:00:00:00:01 10.0.0.2"]. Optional.
+	Addresses []string `json:"addresses,omitempty"`
+
+	// NBConnection is the OVN northbound database's OVSDB connection
+	// string, e.g. "unix:/var/run/ovn/ovnnb_db.sock". Leave empty to
+	// bind the port on OVS only and let an external controller (e.g.
+	// ovn-k8s-cni-overlay) own the Logical_Switch_Port.
+	NBConnection string `json:"nbConnection,omitempty"`
+}
+
+// ContainerConf describes how the plugin should provision the container
+// side of the interface.
+type ContainerConf struct {
+	Engine  string `json:"engine,omitempty"`
+	IfType  string `json:"iftype,omitempty"`
+	NetType string `json:"netType,omitempty"`
+}
+
+// BridgeConf holds the OVS bridge attributes for HostConf.NetType == "bridge".
+type BridgeConf struct {
+	BridgeName string `json:"bridgeName,omitempty"`
+}
+
+// VhostConf holds the vhost-user socket and OVS port attributes for
+// HostConf.IfType == "vhostuser".
+type VhostConf struct {
+	Mode string `json:"mode,omitempty"`
+
+	// IfType overrides the OVS interface type ("dpdkvhostuser" or
+	// "dpdkvhostuserclient") that Mode would otherwise select. Leave
+	// empty to let Mode decide.
+	IfType string `json:"ifType,omitempty"`
+
+	// MTU sets Interface.mtu_request. Leave 0 to use OVS's default.
+	MTU int `json:"mtu,omitempty"`
+
+	// VlanTag, if non-zero, makes this an access port on that VLAN.
+	// Mutually exclusive with Trunks.
+	VlanTag int `json:"vlanTag,omitempty"`
+
+	// Trunks, if non-empty, makes this a trunk port carrying the listed
+	// VLANs. Mutually exclusive with VlanTag.
+	Trunks []int `json:"trunks,omitempty"`
+
+	// OtherConfig and ExternalIDs are copied verbatim into the Port's
+	// other_config and external_ids columns.
+	OtherConfig map[string]string `json:"otherConfig,omitempty"`
+	ExternalIDs map[string]string `json:"externalIDs,omitempty"`
+}